@@ -0,0 +1,52 @@
+// Package lib contains helpers shared across the cluster-version-operator
+// payload handling code.
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Manifest stores the decoded unstructured object for a single manifest file
+// found in the payload, along with bookkeeping useful for error reporting.
+type Manifest struct {
+	// OriginalFilename is set by the payload loader to the path the manifest
+	// was read from, and is empty for manifests constructed in-memory (e.g.
+	// in tests).
+	OriginalFilename string
+
+	// GVK is the GroupVersionKind of the manifest, cached at decode time so
+	// callers do not need to re-derive it from obj.
+	GVK schema.GroupVersionKind
+
+	obj *unstructured.Unstructured
+}
+
+// UnmarshalJSON implements the special casing for Manifest parsing that
+// allows a single manifest to be decoded from an arbitrary JSON or
+// JSON-compatible YAML document.
+func (m *Manifest) UnmarshalJSON(in []byte) error {
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal(in, u); err != nil {
+		return fmt.Errorf("unable to parse manifest: %v", err)
+	}
+	m.GVK = u.GroupVersionKind()
+	m.obj = u
+	return nil
+}
+
+// Object returns the decoded unstructured representation of the manifest.
+func (m *Manifest) Object() *unstructured.Unstructured {
+	return m.obj
+}
+
+// String implements Stringer for use in error messages and logs.
+func (m *Manifest) String() string {
+	if len(m.OriginalFilename) > 0 {
+		return fmt.Sprintf("%q (%s)", m.OriginalFilename, m.GVK)
+	}
+	return m.GVK.String()
+}
@@ -0,0 +1,162 @@
+// Package resourcebuilder defines the interface used by the CVO to apply a
+// single manifest against the cluster, along with the registry that maps a
+// GroupVersionKind to the builder responsible for it.
+package resourcebuilder
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+
+	"github.com/openshift/cluster-version-operator/lib"
+)
+
+// MetaV1ObjectModifierFunc mutates the metadata of an object immediately
+// before it is sent to the API server, e.g. to stamp ownership labels.
+type MetaV1ObjectModifierFunc func(metav1.Object)
+
+// NonDestructiveModifierFunc is like MetaV1ObjectModifierFunc, but is also
+// given the live object (nil if it does not exist yet) so it can avoid
+// clobbering values a user or another operator has already set.
+type NonDestructiveModifierFunc func(desired, existing metav1.Object)
+
+// Reader services a cached Get for a single object, so a builder can avoid
+// a live API call when a fresh enough copy is already cached.
+type Reader interface {
+	// Get returns the cached object for gvk/namespace/name, and whether it
+	// was found. It must not issue a live request per call; it may block
+	// until the cache has completed its initial sync.
+	Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, bool)
+}
+
+// WrittenObjectProvider is implemented by Interface implementations that can
+// report the object they most recently wrote to the API server. A caller
+// keeping a cache warm with its own writes must seed it with this object
+// rather than the pre-modifier manifest: only the written object carries the
+// server-assigned resourceVersion and the modifiers' changes.
+type WrittenObjectProvider interface {
+	// WrittenObject returns the object most recently sent to Create or
+	// Update, or nil if Do has not yet written anything.
+	WrittenObject() *unstructured.Unstructured
+}
+
+// Interface is implemented by the per-GVK builders that know how to apply a
+// single manifest. Do is expected to be idempotent: calling it repeatedly
+// with the same manifest should converge the live object to match it.
+type Interface interface {
+	// WithModifier registers a modifier that is applied to the object
+	// immediately before it is sent to the API server, and returns the
+	// receiver so calls can be chained.
+	WithModifier(MetaV1ObjectModifierFunc) Interface
+
+	// WithNonDestructiveModifier registers a modifier that only sets keys
+	// that are absent from the live object, and returns the receiver so
+	// calls can be chained.
+	WithNonDestructiveModifier(NonDestructiveModifierFunc) Interface
+
+	// WithReader supplies a cache the builder should consult for the live
+	// object before falling back to a direct Get, and returns the receiver
+	// so calls can be chained. Implementations that don't support reading
+	// from a cache may no-op.
+	WithReader(Reader) Interface
+
+	// Do applies the manifest.
+	Do() error
+}
+
+// NonDestructiveLabelModifier returns a NonDestructiveModifierFunc that sets
+// each key in desired on the object's labels, unless the live object already
+// has that key.
+func NonDestructiveLabelModifier(desired map[string]string) NonDestructiveModifierFunc {
+	return nonDestructiveModifier(desired, metav1.Object.GetLabels, metav1.Object.SetLabels)
+}
+
+// NonDestructiveAnnotationModifier returns a NonDestructiveModifierFunc that
+// sets each key in desired on the object's annotations, unless the live
+// object already has that key.
+func NonDestructiveAnnotationModifier(desired map[string]string) NonDestructiveModifierFunc {
+	return nonDestructiveModifier(desired, metav1.Object.GetAnnotations, metav1.Object.SetAnnotations)
+}
+
+func nonDestructiveModifier(
+	desired map[string]string,
+	get func(metav1.Object) map[string]string,
+	set func(metav1.Object, map[string]string),
+) NonDestructiveModifierFunc {
+	return func(obj, existing metav1.Object) {
+		var live map[string]string
+		if existing != nil {
+			live = get(existing)
+		}
+
+		current := get(obj)
+		if current == nil {
+			current = make(map[string]string, len(desired))
+		}
+		for k, v := range desired {
+			if liveV, present := live[k]; present {
+				// obj is sent to the API server as a full-object Update, so
+				// the live value must be copied forward explicitly or it
+				// is simply absent from (and erased by) that Update.
+				current[k] = liveV
+				continue
+			}
+			current[k] = v
+		}
+		set(obj, current)
+	}
+}
+
+// NewInteraceFunc constructs an Interface for a single manifest. Builders are
+// constructed fresh for every apply so they can safely hold manifest-specific
+// state.
+type NewInteraceFunc func(config *rest.Config, m lib.Manifest) Interface
+
+// Mapper is the global registry of GVK to builder constructor. It is
+// populated by each of the specialized builder packages on import, and
+// consulted by the sync worker's default ResourceBuilder for every manifest;
+// a manifest whose GVK has no entry is not applied.
+var Mapper = make(map[schema.GroupVersionKind]NewInteraceFunc)
+
+// ResourceMapper is a scratch registry that can be merged into the global
+// Mapper. It exists primarily so tests can register fakes without mutating
+// global state directly.
+type ResourceMapper struct {
+	m map[schema.GroupVersionKind]NewInteraceFunc
+}
+
+// NewResourceMapper returns an empty ResourceMapper.
+func NewResourceMapper() *ResourceMapper {
+	return &ResourceMapper{m: make(map[schema.GroupVersionKind]NewInteraceFunc)}
+}
+
+// RegisterGVK associates a GVK with the builder constructor responsible for
+// applying it.
+func (r *ResourceMapper) RegisterGVK(gvk schema.GroupVersionKind, fn NewInteraceFunc) {
+	r.m[gvk] = fn
+}
+
+// AddToMap copies every registration into dst.
+func (r *ResourceMapper) AddToMap(dst map[schema.GroupVersionKind]NewInteraceFunc) {
+	for gvk, fn := range r.m {
+		dst[gvk] = fn
+	}
+}
+
+// RetryLaterError is returned by a builder's Do method to indicate that the
+// manifest was not applied because a precondition is not yet satisfied (for
+// example, a readiness wait timed out) and that the caller should requeue
+// and try again rather than treating this as a fatal error.
+type RetryLaterError struct {
+	Reason string
+}
+
+func (e *RetryLaterError) Error() string {
+	if len(e.Reason) == 0 {
+		return "retry later"
+	}
+	return fmt.Sprintf("retry later: %s", e.Reason)
+}
@@ -0,0 +1,48 @@
+package resourcebuilder
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNonDestructiveLabelModifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  map[string]string
+		existing *unstructured.Unstructured
+		obj      *unstructured.Unstructured
+		exp      map[string]string
+	}{{
+		name:     "no live object writes all desired keys",
+		desired:  map[string]string{"a": "1", "b": "2"},
+		existing: nil,
+		obj:      &unstructured.Unstructured{Object: map[string]interface{}{}},
+		exp:      map[string]string{"a": "1", "b": "2"},
+	}, {
+		name:    "live value for a key is preserved",
+		desired: map[string]string{"a": "1", "b": "2"},
+		existing: &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]interface{}{"a": "user-value"},
+			},
+		}},
+		obj: &unstructured.Unstructured{Object: map[string]interface{}{}},
+		exp: map[string]string{"a": "user-value", "b": "2"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			modifier := NonDestructiveLabelModifier(test.desired)
+			if test.existing != nil {
+				modifier(test.obj, test.existing)
+			} else {
+				modifier(test.obj, nil)
+			}
+			if got := test.obj.GetLabels(); !reflect.DeepEqual(got, test.exp) {
+				t.Fatalf("expected %v got %v", test.exp, got)
+			}
+		})
+	}
+}
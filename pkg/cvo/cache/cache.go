@@ -0,0 +1,190 @@
+// Package cache provides a shared, per-GVK informer cache that lets the sync
+// worker service repeated Gets of the same objects across apply passes
+// without a live API call for each one.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resyncPeriod is the informers' resync interval, at which each one replays
+// its cached objects to its handlers; it does not relist from the API
+// server.
+const resyncPeriod = 10 * time.Minute
+
+// Reader services a cached Get for a single object, so a builder can avoid a
+// live API call when the cache already holds a copy of it.
+type Reader interface {
+	// Get returns the cached object for gvk/namespace/name, and whether it
+	// was found. It blocks only until the relevant informer's initial list
+	// has completed, never on a per-call live request.
+	Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, bool)
+}
+
+// Metrics counts how effective the cache has been at avoiding live GETs.
+type Metrics struct {
+	mu     sync.Mutex
+	Hits   int64
+	Misses int64
+}
+
+func (m *Metrics) hit() {
+	m.mu.Lock()
+	m.Hits++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) miss() {
+	m.mu.Lock()
+	m.Misses++
+	m.mu.Unlock()
+}
+
+// HitRate returns the fraction of Get calls served from the cache so far,
+// or 0 if there have been none.
+func (m *Metrics) HitRate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := m.Hits + m.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(m.Hits) / float64(total)
+}
+
+// InformersMap is a controller-runtime-style cache of per-GVK informers over
+// unstructured objects. An informer is started the first time its GVK is
+// requested, using mapper to resolve the GVK to a GVR and to tell namespaced
+// resources (listed/watched cluster-wide, then looked up by
+// namespace/name) apart from cluster-scoped ones (looked up by name alone).
+type InformersMap struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+	stopCh <-chan struct{}
+
+	Metrics Metrics
+
+	mu        sync.Mutex
+	informers map[schema.GroupVersionKind]*informerEntry
+}
+
+type informerEntry struct {
+	informer cache.SharedIndexInformer
+	synced   chan struct{}
+}
+
+// NewInformersMap returns an InformersMap that lists/watches through client,
+// using mapper to resolve each GVK it is asked about. Every informer it
+// starts stops when stopCh is closed.
+func NewInformersMap(client dynamic.Interface, mapper meta.RESTMapper, stopCh <-chan struct{}) *InformersMap {
+	return &InformersMap{
+		client:    client,
+		mapper:    mapper,
+		stopCh:    stopCh,
+		informers: make(map[schema.GroupVersionKind]*informerEntry),
+	}
+}
+
+// Get returns the cached object for gvk/namespace/name, starting and
+// waiting for the initial sync of an informer for gvk if this is the first
+// time it has been requested.
+func (m *InformersMap) Get(gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, bool) {
+	entry, err := m.ensureStarted(gvk)
+	if err != nil {
+		m.Metrics.miss()
+		return nil, false
+	}
+	<-entry.synced
+
+	key := name
+	if len(namespace) > 0 {
+		key = namespace + "/" + name
+	}
+	obj, exists, err := entry.informer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		m.Metrics.miss()
+		return nil, false
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		m.Metrics.miss()
+		return nil, false
+	}
+	m.Metrics.hit()
+	return u.DeepCopy(), true
+}
+
+// Invalidate refreshes the cache entry for obj with the copy the caller
+// just wrote, so a write the sync worker makes itself is immediately
+// visible to the next Get instead of waiting for the informer's watch to
+// deliver it. It is a no-op if no informer has been started for gvk.
+func (m *InformersMap) Invalidate(gvk schema.GroupVersionKind, obj *unstructured.Unstructured) {
+	if obj == nil {
+		return
+	}
+	m.mu.Lock()
+	entry, ok := m.informers[gvk]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	_ = entry.informer.GetStore().Update(obj.DeepCopy())
+}
+
+// ensureStarted returns the informer entry for gvk, starting one (and
+// kicking off its initial sync in the background) if this is the first
+// request for that GVK.
+func (m *InformersMap) ensureStarted(gvk schema.GroupVersionKind) (*informerEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.informers[gvk]; ok {
+		return entry, nil
+	}
+
+	mapping, err := m.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("no REST mapping for %s: %v", gvk, err)
+	}
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	resource := m.client.Resource(mapping.Resource)
+
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			if namespaced {
+				return resource.Namespace(metav1.NamespaceAll).List(context.TODO(), opts)
+			}
+			return resource.List(context.TODO(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			if namespaced {
+				return resource.Namespace(metav1.NamespaceAll).Watch(context.TODO(), opts)
+			}
+			return resource.Watch(context.TODO(), opts)
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	entry := &informerEntry{informer: informer, synced: make(chan struct{})}
+	m.informers[gvk] = entry
+
+	go informer.Run(m.stopCh)
+	go func() {
+		cache.WaitForCacheSync(m.stopCh, informer.HasSynced)
+		close(entry.synced)
+	}()
+
+	return entry, nil
+}
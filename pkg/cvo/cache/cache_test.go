@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clientgotesting "k8s.io/client-go/testing"
+)
+
+func newConfigMap(namespace, name, resourceVersion string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"namespace":       namespace,
+			"name":            name,
+			"resourceVersion": resourceVersion,
+		},
+	}}
+}
+
+func Test_InformersMap_Get(t *testing.T) {
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMapList"}, &unstructured.UnstructuredList{})
+	mapper := testrestmapper.NewTestRESTMapper(scheme, schema.GroupVersion{Version: "v1"})
+
+	client := dynamicfake.NewSimpleDynamicClient(scheme, newConfigMap("default", "a", "1"))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	m := NewInformersMap(client, mapper, stopCh)
+
+	got, ok := m.Get(gvk, "default", "a")
+	if !ok {
+		t.Fatal("expected a to be found")
+	}
+	if got.GetResourceVersion() != "1" {
+		t.Fatalf("expected resourceVersion 1, got %s", got.GetResourceVersion())
+	}
+
+	if _, ok := m.Get(gvk, "default", "missing"); ok {
+		t.Fatal("expected missing to not be found")
+	}
+
+	if m.Metrics.Hits != 1 || m.Metrics.Misses != 1 {
+		t.Fatalf("expected 1 hit and 1 miss, got %+v", m.Metrics)
+	}
+	if rate := m.Metrics.HitRate(); rate != 0.5 {
+		t.Fatalf("expected hit rate 0.5, got %v", rate)
+	}
+
+	// The returned object is a defensive copy: mutating it must not corrupt
+	// the informer's store.
+	got.SetLabels(map[string]string{"mutated": "true"})
+	got2, _ := m.Get(gvk, "default", "a")
+	if len(got2.GetLabels()) != 0 {
+		t.Fatalf("expected store to be unaffected by caller mutation, got %v", got2.GetLabels())
+	}
+}
+
+func Test_InformersMap_Invalidate(t *testing.T) {
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMapList"}, &unstructured.UnstructuredList{})
+	mapper := testrestmapper.NewTestRESTMapper(scheme, schema.GroupVersion{Version: "v1"})
+
+	client := dynamicfake.NewSimpleDynamicClient(scheme, newConfigMap("default", "a", "1"))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	m := NewInformersMap(client, mapper, stopCh)
+
+	if _, ok := m.Get(gvk, "default", "a"); !ok {
+		t.Fatal("expected a to be found")
+	}
+
+	// Invalidating a GVK with no started informer is a no-op.
+	m.Invalidate(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, newConfigMap("default", "b", "1"))
+
+	m.Invalidate(gvk, newConfigMap("default", "a", "2"))
+	got, ok := m.Get(gvk, "default", "a")
+	if !ok {
+		t.Fatal("expected a to still be found after invalidation")
+	}
+	if got.GetResourceVersion() != "2" {
+		t.Fatalf("expected resourceVersion 2 after invalidate, got %s", got.GetResourceVersion())
+	}
+
+	client.PrependReactor("get", "configmaps", func(clientgotesting.Action) (bool, runtime.Object, error) {
+		t.Fatal("Get should have been served from the cache, not the live client")
+		return false, nil, nil
+	})
+	if _, ok := m.Get(gvk, "default", "a"); !ok {
+		t.Fatal("expected a to be found")
+	}
+}
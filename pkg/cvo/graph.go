@@ -0,0 +1,311 @@
+package cvo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/openshift/cluster-version-operator/lib"
+)
+
+// DependsOnAnnotationKey lets a manifest declare that it must be applied
+// after one or more other manifests in the same payload, identified as
+// "<group>/<version>/<kind>/<namespace>/<name>" (namespace is empty for
+// cluster-scoped objects). Multiple references are comma separated.
+const DependsOnAnnotationKey = "v1.cluster-version-operator.operators.openshift.io/depends-on"
+
+const (
+	namespaceKind = "Namespace"
+	crdKind       = "CustomResourceDefinition"
+	crdGroup      = "apiextensions.k8s.io"
+)
+
+// manifestRef identifies a manifest within a payload.
+type manifestRef struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+func refFor(m *lib.Manifest) manifestRef {
+	obj := m.Object()
+	return manifestRef{gvk: m.GVK, namespace: obj.GetNamespace(), name: obj.GetName()}
+}
+
+func parseDependsOnAnnotation(v string) []manifestRef {
+	var refs []manifestRef
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "/", 5)
+		if len(parts) != 5 {
+			continue
+		}
+		refs = append(refs, manifestRef{
+			gvk:       schema.GroupVersionKind{Group: parts[0], Version: parts[1], Kind: parts[2]},
+			namespace: parts[3],
+			name:      parts[4],
+		})
+	}
+	return refs
+}
+
+// buildDependencyGraph returns, for every manifest index, the indices of the
+// manifests it must wait for: those it names explicitly via
+// DependsOnAnnotationKey, plus the implicit rule that a Namespace precedes
+// objects created in it and a CustomResourceDefinition precedes instances of
+// the resource it defines.
+func buildDependencyGraph(manifests []lib.Manifest) [][]int {
+	byRef := make(map[manifestRef]int, len(manifests))
+	for i := range manifests {
+		byRef[refFor(&manifests[i])] = i
+	}
+
+	deps := make([][]int, len(manifests))
+	for i := range manifests {
+		m := &manifests[i]
+		seen := make(map[int]bool)
+		add := func(j int) {
+			if j == i || seen[j] {
+				return
+			}
+			seen[j] = true
+			deps[i] = append(deps[i], j)
+		}
+
+		for _, ref := range parseDependsOnAnnotation(m.Object().GetAnnotations()[DependsOnAnnotationKey]) {
+			if j, ok := byRef[ref]; ok {
+				add(j)
+			}
+		}
+
+		ns := m.Object().GetNamespace()
+		if len(ns) > 0 {
+			if j, ok := byRef[manifestRef{gvk: schema.GroupVersionKind{Version: "v1", Kind: namespaceKind}, name: ns}]; ok {
+				add(j)
+			}
+		}
+
+		if j, ok := crdDependency(manifests, m); ok {
+			add(j)
+		}
+	}
+	return deps
+}
+
+// crdDependency returns the index of the CustomResourceDefinition manifest
+// (if any) that defines the GVK of m, so that instances are always applied
+// after the CRD that registers them.
+func crdDependency(manifests []lib.Manifest, m *lib.Manifest) (int, bool) {
+	for i := range manifests {
+		candidate := &manifests[i]
+		if candidate.GVK.Group != crdGroup || candidate.GVK.Kind != crdKind {
+			continue
+		}
+		obj := candidate.Object()
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		if group == m.GVK.Group && kind == m.GVK.Kind {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// graphResult is the outcome of applying one set of indices through
+// applyIndices.
+type graphResult struct {
+	// retry holds indices whose error was requeue-eligible and should be
+	// attempted again in a later pass, plus any indices that were pruned
+	// only because a dependency of theirs landed in retry (so they must be
+	// retried alongside it rather than silently dropped).
+	retry []int
+	// err is the first non-requeue-eligible error encountered. When err is
+	// non-nil and the pass is configured to abort on failure, indices that
+	// never ran are simply absent from both retry and any success set.
+	err error
+}
+
+// applyIndices applies the manifests named by indices, respecting the
+// dependency edges between them recorded in deps (edges to indices outside
+// the set are ignored, since those manifests already completed in an
+// earlier pass). Up to w.effectiveConcurrency() manifests are applied at
+// once; a manifest whose dependency failed is pruned rather than attempted.
+// A pruned manifest is carried forward into graphResult.retry unless the
+// dependency that pruned it was a fatal (non-requeue-eligible) failure, in
+// which case the whole apply is already going to be retried from scratch
+// next cycle.
+//
+// When finalPass is false, a manifest that exhausts its retry budget with a
+// requeue-eligible error is recorded for a later pass rather than treated as
+// fatal. When finalPass is true, any remaining error is final.
+//
+// When the effective concurrency is 1, a fatal error stops the whole set
+// immediately, matching the historical strictly-serial behavior. With
+// concurrency above 1, a fatal or exhausted manifest only prunes its own
+// dependents; sibling branches continue.
+func (w *SyncWorker) applyIndices(ctx context.Context, manifests []lib.Manifest, deps [][]int, indices []int, status *statusWrapper, total int, finalPass bool) graphResult {
+	concurrency := w.effectiveConcurrency()
+	abortOnFailure := concurrency <= 1
+
+	inSet := make(map[int]bool, len(indices))
+	for _, i := range indices {
+		inSet[i] = true
+	}
+
+	indegree := make(map[int]int, len(indices))
+	successors := make(map[int][]int, len(indices))
+	for _, i := range indices {
+		for _, d := range deps[i] {
+			if inSet[d] {
+				indegree[i]++
+				successors[d] = append(successors[d], i)
+			}
+		}
+	}
+
+	var initialReady []int
+	for _, i := range indices {
+		if indegree[i] == 0 {
+			initialReady = append(initialReady, i)
+		}
+	}
+	sort.Ints(initialReady)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, concurrency)
+	var result graphResult
+	pruned := make(map[int]bool)
+	ran := make(map[int]bool, len(indices))
+	var fatalNodes []int
+
+	// Dispatch ready nodes one dependency wave at a time: every node in
+	// wave is already known to be runnable (indegree 0) before any of them
+	// starts, so the wave can run out fully in parallel up to concurrency,
+	// and the next wave is only computed once the whole wait group for
+	// this one has drained. Earlier revisions instead had a finishing
+	// goroutine call back into the dispatch logic directly, synchronously,
+	// while still holding its own sem slot — with concurrency 1 that slot
+	// is the only one that exists, so the call blocked forever waiting for
+	// a slot it was itself holding. Finishing the wave via wg.Wait() in
+	// this outer loop, instead of recursing from inside a goroutine, never
+	// holds a slot while waiting for one.
+	for wave := initialReady; len(wave) > 0; {
+		if abortOnFailure && runCtx.Err() != nil {
+			break
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var next []int
+
+		for _, i := range wave {
+			if abortOnFailure && runCtx.Err() != nil {
+				break
+			}
+			i := i
+			sem <- struct{}{}
+			if abortOnFailure && runCtx.Err() != nil {
+				<-sem
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				m := &manifests[i]
+				status.Report(i, total, fmt.Sprintf("applying %s", m))
+
+				err := w.applyManifestWithRetry(runCtx, m)
+
+				mu.Lock()
+				defer mu.Unlock()
+				ran[i] = true
+				if err != nil {
+					requeue := !finalPass && shouldRequeueOnErr(err, m)
+					if requeue {
+						result.retry = append(result.retry, i)
+					} else {
+						if result.err == nil {
+							result.err = err
+						}
+						fatalNodes = append(fatalNodes, i)
+						if abortOnFailure {
+							cancel()
+						}
+					}
+					for _, s := range successors[i] {
+						pruned[s] = true
+					}
+				}
+				if runCtx.Err() == nil {
+					for _, s := range successors[i] {
+						if pruned[s] {
+							continue
+						}
+						indegree[s]--
+						if indegree[s] == 0 {
+							next = append(next, s)
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		sort.Ints(next)
+		wave = next
+	}
+
+	// A manifest that never ran was pruned because some dependency of its
+	// failed. If that dependency's error was fatal, the whole apply is
+	// already going to be retried from scratch next cycle, so leaving the
+	// manifest unapplied this pass is fine. But if every blocking ancestor
+	// only needs a retry, the manifest must be carried into the retry set
+	// too: the final pass runs over exactly that set, so a manifest dropped
+	// here would never be attempted at all, even though its ancestor's
+	// retry might go on to succeed.
+	fatallyBlocked := make(map[int]bool, len(fatalNodes))
+	queue := append([]int(nil), fatalNodes...)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, s := range successors[n] {
+			if fatallyBlocked[s] {
+				continue
+			}
+			fatallyBlocked[s] = true
+			queue = append(queue, s)
+		}
+	}
+	if !abortOnFailure || result.err == nil {
+		for _, i := range indices {
+			if !ran[i] && !fatallyBlocked[i] {
+				result.retry = append(result.retry, i)
+			}
+		}
+	}
+
+	sort.Ints(result.retry)
+	return result
+}
+
+// effectiveConcurrency returns the worker pool size apply should use: at
+// least 1, defaulting to strictly serial execution when MaxConcurrency is
+// unset.
+func (w *SyncWorker) effectiveConcurrency() int {
+	if w.MaxConcurrency > 1 {
+		return w.MaxConcurrency
+	}
+	return 1
+}
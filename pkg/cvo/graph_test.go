@@ -0,0 +1,299 @@
+package cvo
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/openshift/cluster-version-operator/lib"
+)
+
+func newGraphManifest(t *testing.T, raw string) lib.Manifest {
+	t.Helper()
+	var m lib.Manifest
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestBuildDependencyGraph(t *testing.T) {
+	manifests := []lib.Manifest{
+		newGraphManifest(t, `{
+			"apiVersion": "v1",
+			"kind": "Namespace",
+			"metadata": {"name": "test-ns"}
+		}`),
+		newGraphManifest(t, `{
+			"apiVersion": "v1",
+			"kind": "ConfigMap",
+			"metadata": {"namespace": "test-ns", "name": "cm"}
+		}`),
+		newGraphManifest(t, `{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind": "CustomResourceDefinition",
+			"metadata": {"name": "things.test.cvo.io"},
+			"spec": {"group": "test.cvo.io", "names": {"kind": "Thing"}}
+		}`),
+		newGraphManifest(t, `{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "Thing",
+			"metadata": {"namespace": "test-ns", "name": "a-thing"}
+		}`),
+		newGraphManifest(t, `{
+			"apiVersion": "v1",
+			"kind": "ConfigMap",
+			"metadata": {
+				"namespace": "test-ns",
+				"name": "depends-on-cm",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/depends-on": "/v1/ConfigMap/test-ns/cm"
+				}
+			}
+		}`),
+	}
+	// namespaceIdx, cmIdx, crdIdx, thingIdx, dependsOnCmIdx
+	const (
+		namespaceIdx = iota
+		cmIdx
+		crdIdx
+		thingIdx
+		dependsOnCmIdx
+	)
+
+	deps := buildDependencyGraph(manifests)
+
+	cases := []struct {
+		name string
+		idx  int
+		want []int
+	}{
+		{"namespaced object depends on its Namespace", cmIdx, []int{namespaceIdx}},
+		{"CRD instance depends on its CRD and Namespace", thingIdx, []int{namespaceIdx, crdIdx}},
+		{"CRD itself has no implicit dependency", crdIdx, nil},
+		{"explicit depends-on is honored alongside the implicit Namespace edge", dependsOnCmIdx, []int{cmIdx, namespaceIdx}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := append([]int(nil), deps[c.idx]...)
+			sort.Ints(got)
+			want := append([]int(nil), c.want...)
+			sort.Ints(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected deps %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+// fakeApplier is a minimal ResourceBuilder used to exercise applyIndices
+// directly, without the resourcebuilder.Mapper-based production wiring that
+// sync_test.go's higher-level SyncWorker.apply tests go through.
+type fakeApplier struct {
+	mu       sync.Mutex
+	order    []string
+	attempts map[string]int
+	errFunc  map[string]func(attempt int) error
+}
+
+func newFakeApplier() *fakeApplier {
+	return &fakeApplier{attempts: make(map[string]int), errFunc: make(map[string]func(int) error)}
+}
+
+func (f *fakeApplier) Apply(m *lib.Manifest) error {
+	name := m.Object().GetName()
+	f.mu.Lock()
+	f.order = append(f.order, name)
+	f.attempts[name]++
+	n := f.attempts[name]
+	fn := f.errFunc[name]
+	f.mu.Unlock()
+	if fn == nil {
+		return nil
+	}
+	return fn(n)
+}
+
+func (f *fakeApplier) order_() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, len(f.order))
+	copy(out, f.order)
+	return out
+}
+
+func indexOfName(order []string, name string) int {
+	for i, n := range order {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Test_applyIndices_serialDependencyOrder guards against a deadlock in the
+// default (MaxConcurrency unset, so effectiveConcurrency()==1) configuration
+// whenever a dependency edge exists: a goroutine that finishes a node used
+// to recurse into the scheduling logic for its now-ready successor while
+// still holding its own semaphore slot, and with the single serial slot
+// that recursive call could never acquire a slot of its own.
+func Test_applyIndices_serialDependencyOrder(t *testing.T) {
+	manifests := []lib.Manifest{
+		newGraphManifest(t, `{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestA",
+			"metadata": {"namespace": "default", "name": "testa"}
+		}`),
+		newGraphManifest(t, `{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestB",
+			"metadata": {
+				"namespace": "default",
+				"name": "testb",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/depends-on": "test.cvo.io/v1/TestA/default/testa"
+				}
+			}
+		}`),
+	}
+	deps := buildDependencyGraph(manifests)
+	indices := []int{0, 1}
+
+	applier := newFakeApplier()
+	w := &SyncWorker{}
+	w.backoff.Steps = 1
+	w.builder = applier
+	status := &statusWrapper{w: w, previousStatus: w.Status()}
+
+	done := make(chan graphResult, 1)
+	go func() {
+		done <- w.applyIndices(context.Background(), manifests, deps, indices, status, len(manifests), false)
+	}()
+
+	select {
+	case result := <-done:
+		if result.err != nil {
+			t.Fatalf("unexpected error: %v", result.err)
+		}
+		order := applier.order_()
+		aIdx, bIdx := indexOfName(order, "testa"), indexOfName(order, "testb")
+		if aIdx == -1 || bIdx == -1 {
+			t.Fatalf("expected both manifests to be applied, got %v", order)
+		}
+		if aIdx > bIdx {
+			t.Fatalf("expected testa (depended on) to be applied before testb, got %v", order)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("applyIndices deadlocked with the default serial concurrency and a dependency edge")
+	}
+}
+
+// Test_applyIndices_retryCarriesBlockedDependents guards against a
+// dependent manifest being silently dropped for an entire pass when the
+// dependency it waits on exhausts its retry budget (rather than failing
+// fatally): the dependent must come back in graphResult.retry alongside its
+// dependency, not be pruned and forgotten.
+func Test_applyIndices_retryCarriesBlockedDependents(t *testing.T) {
+	manifests := []lib.Manifest{
+		newGraphManifest(t, `{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestA",
+			"metadata": {
+				"namespace": "default",
+				"name": "testa",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/requeue-on-error": "NoMatch"
+				}
+			}
+		}`),
+		newGraphManifest(t, `{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestB",
+			"metadata": {
+				"namespace": "default",
+				"name": "testb",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/depends-on": "test.cvo.io/v1/TestA/default/testa"
+				}
+			}
+		}`),
+	}
+	deps := buildDependencyGraph(manifests)
+	indices := []int{0, 1}
+
+	applier := newFakeApplier()
+	applier.errFunc["testa"] = func(int) error { return &meta.NoResourceMatchError{} }
+
+	w := &SyncWorker{MaxConcurrency: 4}
+	w.backoff.Steps = 2
+	w.builder = applier
+	status := &statusWrapper{w: w, previousStatus: w.Status()}
+
+	result := w.applyIndices(context.Background(), manifests, deps, indices, status, len(manifests), false)
+	if result.err != nil {
+		t.Fatalf("expected no fatal error, got %v", result.err)
+	}
+
+	got := append([]int(nil), result.retry...)
+	sort.Ints(got)
+	if want := []int{0, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected both testa and its blocked dependent testb carried into retry, got %v", got)
+	}
+	if n := indexOfName(applier.order_(), "testb"); n != -1 {
+		t.Fatalf("expected testb not to run in the first pass, got %v", applier.order_())
+	}
+}
+
+// Test_applyIndices_fatalErrorPrunesDependents covers the complementary
+// case: a fatal (non-requeue-eligible) error must still permanently prune
+// its dependents rather than carrying them into retry, since the whole
+// apply is already going to be retried from scratch on the next cycle.
+func Test_applyIndices_fatalErrorPrunesDependents(t *testing.T) {
+	manifests := []lib.Manifest{
+		newGraphManifest(t, `{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestA",
+			"metadata": {"namespace": "default", "name": "testa"}
+		}`),
+		newGraphManifest(t, `{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestB",
+			"metadata": {
+				"namespace": "default",
+				"name": "testb",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/depends-on": "test.cvo.io/v1/TestA/default/testa"
+				}
+			}
+		}`),
+	}
+	deps := buildDependencyGraph(manifests)
+	indices := []int{0, 1}
+
+	applier := newFakeApplier()
+	applier.errFunc["testa"] = func(int) error { return &meta.NoResourceMatchError{} }
+
+	w := &SyncWorker{MaxConcurrency: 4}
+	w.backoff.Steps = 2
+	w.builder = applier
+	status := &statusWrapper{w: w, previousStatus: w.Status()}
+
+	// finalPass == true: TestA's error can no longer be treated as
+	// requeue-eligible, so it is fatal.
+	result := w.applyIndices(context.Background(), manifests, deps, indices, status, len(manifests), true)
+	if result.err == nil {
+		t.Fatal("expected a fatal error")
+	}
+	if len(result.retry) != 0 {
+		t.Fatalf("expected no retries once the blocking error is fatal, got %v", result.retry)
+	}
+	if n := indexOfName(applier.order_(), "testb"); n != -1 {
+		t.Fatalf("expected testb to never run once its dependency failed fatally, got %v", applier.order_())
+	}
+}
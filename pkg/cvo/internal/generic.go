@@ -0,0 +1,122 @@
+// Package internal implements the generic, unstructured-object builder. It
+// is constructed directly by its callers; a manifest whose GVK has no entry
+// in resourcebuilder.Mapper does not fall back to it.
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/openshift/cluster-version-operator/lib"
+	"github.com/openshift/cluster-version-operator/lib/resourcebuilder"
+)
+
+// genericBuilder applies a single manifest as an unstructured object via the
+// dynamic client, without any type-specific knowledge.
+type genericBuilder struct {
+	client                  dynamic.ResourceInterface
+	obj                     *unstructured.Unstructured
+	modifiers               []resourcebuilder.MetaV1ObjectModifierFunc
+	nonDestructiveModifiers []resourcebuilder.NonDestructiveModifierFunc
+	reader                  resourcebuilder.Reader
+	written                 *unstructured.Unstructured
+}
+
+// NewGenericBuilder returns a builder that applies m against client, which
+// must already be scoped to the manifest's GroupVersionResource and
+// namespace.
+func NewGenericBuilder(client dynamic.ResourceInterface, m lib.Manifest) (resourcebuilder.Interface, error) {
+	obj := m.Object()
+	if obj == nil {
+		return nil, fmt.Errorf("generic builder requires a decoded manifest")
+	}
+	return &genericBuilder{client: client, obj: obj.DeepCopy()}, nil
+}
+
+func (b *genericBuilder) WithModifier(m resourcebuilder.MetaV1ObjectModifierFunc) resourcebuilder.Interface {
+	b.modifiers = append(b.modifiers, m)
+	return b
+}
+
+func (b *genericBuilder) WithNonDestructiveModifier(m resourcebuilder.NonDestructiveModifierFunc) resourcebuilder.Interface {
+	b.nonDestructiveModifiers = append(b.nonDestructiveModifiers, m)
+	return b
+}
+
+func (b *genericBuilder) WithReader(r resourcebuilder.Reader) resourcebuilder.Interface {
+	b.reader = r
+	return b
+}
+
+// WrittenObject returns the object most recently sent to Create or Update,
+// including its server-assigned resourceVersion, or nil if Do has not yet
+// written anything.
+func (b *genericBuilder) WrittenObject() *unstructured.Unstructured {
+	return b.written
+}
+
+func (b *genericBuilder) Do() error {
+	for _, m := range b.modifiers {
+		m(b.obj)
+	}
+
+	ctx := context.TODO()
+
+	var existing *unstructured.Unstructured
+	if b.reader != nil {
+		if cached, ok := b.reader.Get(b.obj.GroupVersionKind(), b.obj.GetNamespace(), b.obj.GetName()); ok {
+			existing = cached
+		}
+	}
+	notFound := false
+	if existing == nil {
+		got, err := b.client.Get(ctx, b.obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			notFound = true
+		} else {
+			existing = got
+		}
+	}
+
+	// Non-destructive modifiers need to see the live object so they can
+	// leave keys a user (or another operator) has already set untouched.
+	var live metav1.Object
+	if !notFound {
+		live = existing
+	}
+	for _, m := range b.nonDestructiveModifiers {
+		m(b.obj, live)
+	}
+
+	if notFound {
+		created, err := b.client.Create(ctx, b.obj, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		b.written = created
+	} else {
+		b.obj.SetResourceVersion(existing.GetResourceVersion())
+		updated, err := b.client.Update(ctx, b.obj, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		b.written = updated
+	}
+
+	spec, err := waitForSpecFromAnnotations(b.obj.GetAnnotations())
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		return nil
+	}
+	return b.waitFor(ctx, spec)
+}
@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/openshift/cluster-version-operator/lib"
+	"github.com/openshift/cluster-version-operator/lib/resourcebuilder"
+)
+
+func newManifest(t *testing.T, raw string) lib.Manifest {
+	t.Helper()
+	var m lib.Manifest
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func Test_genericBuilder_Do_waitFor(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}
+	gvr := schema.GroupVersionResource{Group: "test.cvo.io", Version: "v1", Resource: "testas"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestAList"}, &unstructured.UnstructuredList{})
+
+	m := newManifest(t, `{
+		"apiVersion": "test.cvo.io/v1",
+		"kind": "TestA",
+		"metadata": {
+			"namespace": "default",
+			"name": "testa",
+			"annotations": {
+				"v1.cluster-version-operator.operators.openshift.io/wait-for": "condition=Available,status=True,timeout=1s"
+			}
+		}
+	}`)
+
+	client := dynamicfake.NewSimpleDynamicClient(scheme)
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("testas", func(_ clientgotesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+
+	builder, err := NewGenericBuilder(client.Resource(gvr).Namespace("default"), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- builder.Do() }()
+
+	// Publish a status transition that does not yet satisfy the condition,
+	// followed by one that does.
+	watcher.Modify(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "test.cvo.io/v1",
+		"kind":       "TestA",
+		"metadata":   map[string]interface{}{"name": "testa", "namespace": "default"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "False"},
+			},
+		},
+	}})
+	watcher.Modify(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "test.cvo.io/v1",
+		"kind":       "TestA",
+		"metadata":   map[string]interface{}{"name": "testa", "namespace": "default"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "True"},
+			},
+		},
+	}})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for Do to return")
+	}
+}
+
+// Test_genericBuilder_Do_waitFor_alreadySatisfied guards against a
+// regression where waitFor only checked future watch events: a manifest
+// that had already converged in an earlier reconcile pass, and whose status
+// never changes again, would otherwise block for the full wait-for timeout
+// on every later pass instead of returning immediately.
+func Test_genericBuilder_Do_waitFor_alreadySatisfied(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}
+	gvr := schema.GroupVersionResource{Group: "test.cvo.io", Version: "v1", Resource: "testas"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestAList"}, &unstructured.UnstructuredList{})
+
+	m := newManifest(t, `{
+		"apiVersion": "test.cvo.io/v1",
+		"kind": "TestA",
+		"metadata": {
+			"namespace": "default",
+			"name": "testa",
+			"annotations": {
+				"v1.cluster-version-operator.operators.openshift.io/wait-for": "condition=Available,status=True,timeout=50ms"
+			}
+		}
+	}`)
+
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "test.cvo.io/v1",
+		"kind":       "TestA",
+		"metadata":   map[string]interface{}{"name": "testa", "namespace": "default"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "True"},
+			},
+		},
+	}}
+	client := dynamicfake.NewSimpleDynamicClient(scheme, existing)
+	// The fake tracker replaces the whole stored object on Update, which
+	// would drop the converged status; keep the existing object as is, the
+	// way an API server keeps status across an update of the main resource.
+	client.PrependReactor("update", "testas", func(_ clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, existing.DeepCopy(), nil
+	})
+	// No watch event is ever delivered: if waitFor falls back to watching
+	// instead of checking the current object first, this blocks until the
+	// 50ms timeout and the test below catches it as a RetryLaterError.
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("testas", func(_ clientgotesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+
+	builder, err := NewGenericBuilder(client.Resource(gvr).Namespace("default"), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := builder.Do(); err != nil {
+		t.Fatalf("expected an already-satisfied wait-for to return immediately, got %v", err)
+	}
+}
+
+func Test_genericBuilder_Do_waitFor_timeout(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}
+	gvr := schema.GroupVersionResource{Group: "test.cvo.io", Version: "v1", Resource: "testas"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestAList"}, &unstructured.UnstructuredList{})
+
+	m := newManifest(t, `{
+		"apiVersion": "test.cvo.io/v1",
+		"kind": "TestA",
+		"metadata": {
+			"namespace": "default",
+			"name": "testa",
+			"annotations": {
+				"v1.cluster-version-operator.operators.openshift.io/wait-for": "condition=Available,status=True,timeout=50ms"
+			}
+		}
+	}`)
+
+	client := dynamicfake.NewSimpleDynamicClient(scheme)
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("testas", func(_ clientgotesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+
+	builder, err := NewGenericBuilder(client.Resource(gvr).Namespace("default"), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = builder.Do()
+	if _, ok := err.(*resourcebuilder.RetryLaterError); !ok {
+		t.Fatalf("expected a RetryLaterError, got %v", err)
+	}
+}
+
+func Test_genericBuilder_Do_nonDestructiveModifier_preservesExistingLabel(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}
+	gvr := schema.GroupVersionResource{Group: "test.cvo.io", Version: "v1", Resource: "testas"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestAList"}, &unstructured.UnstructuredList{})
+
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "test.cvo.io/v1",
+		"kind":       "TestA",
+		"metadata": map[string]interface{}{
+			"name":      "testa",
+			"namespace": "default",
+			"labels":    map[string]interface{}{"release.openshift.io/version": "user-pinned"},
+		},
+	}}
+	client := dynamicfake.NewSimpleDynamicClient(scheme, existing)
+
+	m := newManifest(t, `{
+		"apiVersion": "test.cvo.io/v1",
+		"kind": "TestA",
+		"metadata": {
+			"namespace": "default",
+			"name": "testa"
+		}
+	}`)
+
+	builder, err := NewGenericBuilder(client.Resource(gvr).Namespace("default"), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder = builder.WithNonDestructiveModifier(resourcebuilder.NonDestructiveLabelModifier(map[string]string{
+		"release.openshift.io/version": "v1.0.0",
+	}))
+
+	if err := builder.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.Resource(gvr).Namespace("default").Get(context.TODO(), "testa", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.GetLabels()["release.openshift.io/version"]; v != "user-pinned" {
+		t.Fatalf("expected pre-existing label to survive reconcile, got %q", v)
+	}
+}
+
+// Test_genericBuilder_Do_writtenObject guards against a regression where a
+// caller seeding a cache after Do seeded it with the pre-modifier manifest
+// instead of what was actually written: WrittenObject must reflect the
+// modifiers applied by Do, and the server-assigned resourceVersion.
+func Test_genericBuilder_Do_writtenObject(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}
+	gvr := schema.GroupVersionResource{Group: "test.cvo.io", Version: "v1", Resource: "testas"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestAList"}, &unstructured.UnstructuredList{})
+	client := dynamicfake.NewSimpleDynamicClient(scheme)
+
+	m := newManifest(t, `{
+		"apiVersion": "test.cvo.io/v1",
+		"kind": "TestA",
+		"metadata": {
+			"namespace": "default",
+			"name": "testa"
+		}
+	}`)
+
+	builder, err := NewGenericBuilder(client.Resource(gvr).Namespace("default"), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder = builder.WithModifier(func(obj metav1.Object) {
+		obj.SetLabels(map[string]string{"release.openshift.io/version": "v1.0.0"})
+	})
+
+	wp, ok := builder.(resourcebuilder.WrittenObjectProvider)
+	if !ok {
+		t.Fatal("expected genericBuilder to implement resourcebuilder.WrittenObjectProvider")
+	}
+	if got := wp.WrittenObject(); got != nil {
+		t.Fatalf("expected no written object before Do, got %v", got)
+	}
+
+	if err := builder.Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	created := wp.WrittenObject()
+	if created == nil {
+		t.Fatal("expected a written object after Do")
+	}
+	if v := created.GetLabels()["release.openshift.io/version"]; v != "v1.0.0" {
+		t.Fatalf("expected the written object to carry the modifier's label, got %q", v)
+	}
+
+	// Applying again goes through Update, against the object just created,
+	// and must expose that object's resourceVersion, not an empty one.
+	builder2, err := NewGenericBuilder(client.Resource(gvr).Namespace("default"), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder2.Do(); err != nil {
+		t.Fatal(err)
+	}
+	updated := builder2.(resourcebuilder.WrittenObjectProvider).WrittenObject()
+	if updated == nil {
+		t.Fatal("expected a written object after the second Do")
+	}
+	if updated.GetResourceVersion() != created.GetResourceVersion() {
+		t.Fatalf("expected the written object's resourceVersion to match what the server assigned, got %q want %q", updated.GetResourceVersion(), created.GetResourceVersion())
+	}
+}
@@ -0,0 +1,522 @@
+package cvo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/openshift/cluster-version-operator/lib"
+	"github.com/openshift/cluster-version-operator/lib/resourcebuilder"
+	cvocache "github.com/openshift/cluster-version-operator/pkg/cvo/cache"
+)
+
+// RequeueOnErrorAnnotationKey is set on a manifest to indicate that certain
+// classes of apply errors should not be treated as fatal, but should instead
+// cause the manifest to be retried. The value is a comma separated list of
+// the error classes understood by requeueOnErrorMatchers.
+const RequeueOnErrorAnnotationKey = "v1.cluster-version-operator.operators.openshift.io/requeue-on-error"
+
+// requeueOnErrorMatchers maps the well-known error class names accepted in
+// the requeue-on-error annotation to the predicate used to recognize them.
+var requeueOnErrorMatchers = map[string]func(error) bool{
+	"NoMatch":  meta.IsNoMatchError,
+	"NotFound": errors.IsNotFound,
+}
+
+// hasRequeueOnErrorAnnotation returns whether annos carries the
+// requeue-on-error annotation, and if so the parsed list of error classes it
+// names.
+func hasRequeueOnErrorAnnotation(annos map[string]string) (bool, []string) {
+	v, ok := annos[RequeueOnErrorAnnotationKey]
+	if !ok {
+		return false, nil
+	}
+	return true, strings.Split(v, ",")
+}
+
+// shouldRequeueOnErr determines whether err, encountered while applying m,
+// should cause the manifest to be retried rather than failing the sync.
+func shouldRequeueOnErr(err error, m *lib.Manifest) bool {
+	if err == nil {
+		return false
+	}
+	cause := err
+	if ue, ok := err.(*updateError); ok {
+		cause = ue.cause
+	}
+	if _, ok := cause.(*resourcebuilder.RetryLaterError); ok {
+		return true
+	}
+
+	ok, errClasses := hasRequeueOnErrorAnnotation(m.Object().GetAnnotations())
+	if !ok {
+		return false
+	}
+	for _, class := range errClasses {
+		if matches, ok := requeueOnErrorMatchers[class]; ok && matches(cause) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequeueUntilAnnotationKey lets a manifest declare a precondition on the
+// state of other objects in the cluster, expressed as a label selector
+// rather than a fixed name: the manifest is requeued until at least N
+// objects matching the selector exist and are ready. The value has the form
+// "<group>/<version>/<resource>?labelSelector=<url-encoded-selector>&count>=<N>",
+// for example
+// "apps/v1/deployments?labelSelector=app%3Dfoo&count>=3".
+const RequeueUntilAnnotationKey = "v1.cluster-version-operator.operators.openshift.io/requeue-until"
+
+// requeueUntilSpec is the parsed form of RequeueUntilAnnotationKey.
+type requeueUntilSpec struct {
+	gvr           schema.GroupVersionResource
+	labelSelector string
+	minCount      int
+}
+
+// parseRequeueUntilAnnotation parses RequeueUntilAnnotationKey out of annos.
+// It returns a nil spec when the annotation is absent.
+func parseRequeueUntilAnnotation(annos map[string]string) (*requeueUntilSpec, error) {
+	v, ok := annos[RequeueUntilAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	target, query := v, ""
+	if i := strings.Index(v, "?"); i >= 0 {
+		target, query = v[:i], v[i+1:]
+	}
+	parts := strings.SplitN(target, "/", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid %s annotation %q: expected <group>/<version>/<resource>", RequeueUntilAnnotationKey, v)
+	}
+	spec := &requeueUntilSpec{gvr: schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}}
+
+	for _, pair := range strings.Split(query, "&") {
+		if len(pair) == 0 {
+			continue
+		}
+		key, val := pair, ""
+		if i := strings.Index(pair, "="); i >= 0 {
+			key, val = pair[:i], pair[i+1:]
+		}
+		switch {
+		case strings.HasPrefix(key, "labelSelector"):
+			decoded, err := url.QueryUnescape(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s annotation %q: %v", RequeueUntilAnnotationKey, v, err)
+			}
+			spec.labelSelector = decoded
+		case strings.HasPrefix(key, "count"):
+			// The operator (">=", etc.) is folded into key and not
+			// checked: every count directive is read as at-least-N.
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s annotation %q: %v", RequeueUntilAnnotationKey, v, err)
+			}
+			spec.minCount = n
+		}
+	}
+	return spec, nil
+}
+
+// isUnstructuredReady reports whether obj carries a status.conditions entry
+// of type Ready with status True. Objects with no conditions at all are
+// considered ready, since many resource kinds don't model readiness that
+// way.
+func isUnstructuredReady(obj *unstructured.Unstructured) bool {
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil || !found {
+		return true
+	}
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if fmt.Sprintf("%v", cond["type"]) == "Ready" && fmt.Sprintf("%v", cond["status"]) == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// updateError wraps an error encountered while applying a manifest with the
+// identity of the manifest that failed, so callers further up the stack can
+// decide how to react without re-deriving that context.
+type updateError struct {
+	Reason string
+	Name   string
+
+	cause error
+}
+
+func (e *updateError) Error() string {
+	if len(e.Name) == 0 {
+		return e.cause.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Name, e.cause)
+}
+
+// ResourceBuilder applies a single manifest to the cluster.
+type ResourceBuilder interface {
+	Apply(m *lib.Manifest) error
+}
+
+// PreserveLabelsAnnotationKey lets a manifest opt individual release-owned
+// label keys out of the CVO's default destructive reconcile behavior: a key
+// named here is only written when it is not already present on the live
+// object, so a value set by a user or another operator survives reconcile.
+const PreserveLabelsAnnotationKey = "v1.cluster-version-operator.operators.openshift.io/preserve-labels"
+
+// preservedLabelKeys returns the label keys a manifest has opted into
+// non-destructive handling via PreserveLabelsAnnotationKey.
+func preservedLabelKeys(annos map[string]string) []string {
+	v, ok := annos[PreserveLabelsAnnotationKey]
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// resourceBuilder is the default ResourceBuilder: it dispatches to the
+// builder registered in resourcebuilder.Mapper for the manifest's GVK. A
+// manifest whose GVK has no entry there fails with an error; there is no
+// fallback to the generic unstructured builder.
+type resourceBuilder struct {
+	config    *rest.Config
+	modifiers []resourcebuilder.MetaV1ObjectModifierFunc
+
+	// releaseLabels are the release-owned labels stamped onto every applied
+	// manifest. They are destructive (always overwritten) by default; a
+	// manifest can preserve individual keys via PreserveLabelsAnnotationKey.
+	releaseLabels map[string]string
+
+	// dynamicClient is used to evaluate RequeueUntilAnnotationKey. It is
+	// constructed lazily from config the first time a manifest needs it.
+	dynamicClient dynamic.Interface
+
+	// cache, if non-nil, is consulted for a manifest's live object before
+	// falling back to a direct Get, and is kept warm with the result of
+	// every write this builder makes.
+	cache *cvocache.InformersMap
+}
+
+// NewResourceBuilder returns the default ResourceBuilder used by the sync
+// worker, talking to the cluster with config. informers may be nil, in
+// which case every apply does its own direct Get.
+func NewResourceBuilder(config *rest.Config, informers *cvocache.InformersMap) ResourceBuilder {
+	return &resourceBuilder{config: config, cache: informers}
+}
+
+// setReleaseLabels updates the release-owned labels the builder stamps onto
+// every manifest it applies. It is called by SyncWorker.apply once per pass
+// with labels derived from the payload being applied.
+func (b *resourceBuilder) setReleaseLabels(labels map[string]string) {
+	b.releaseLabels = labels
+}
+
+func (b *resourceBuilder) Apply(m *lib.Manifest) error {
+	newBuilder, ok := resourcebuilder.Mapper[m.GVK]
+	if !ok {
+		return fmt.Errorf("no resource builder registered for %s", m.GVK)
+	}
+	builder := newBuilder(b.config, *m)
+	for _, modifier := range b.modifiers {
+		builder = builder.WithModifier(modifier)
+	}
+	if b.cache != nil {
+		builder = builder.WithReader(b.cache)
+	}
+
+	destructive, preserved := splitPreservedLabels(b.releaseLabels, preservedLabelKeys(m.Object().GetAnnotations()))
+	if len(destructive) > 0 {
+		labels := destructive
+		builder = builder.WithModifier(func(obj metav1.Object) {
+			current := obj.GetLabels()
+			if current == nil {
+				current = make(map[string]string, len(labels))
+			}
+			for k, v := range labels {
+				current[k] = v
+			}
+			obj.SetLabels(current)
+		})
+	}
+	if len(preserved) > 0 {
+		builder = builder.WithNonDestructiveModifier(resourcebuilder.NonDestructiveLabelModifier(preserved))
+	}
+
+	if err := builder.Do(); err != nil {
+		return err
+	}
+	if b.cache != nil {
+		// Seed the cache with the object actually written, not the
+		// pre-modifier manifest: only the written object carries the
+		// release labels, non-destructive merges, and server-assigned
+		// resourceVersion the next Get must see.
+		if wp, ok := builder.(resourcebuilder.WrittenObjectProvider); ok {
+			if written := wp.WrittenObject(); written != nil {
+				b.cache.Invalidate(m.GVK, written)
+			}
+		}
+	}
+	return b.checkRequeueUntil(m)
+}
+
+// checkRequeueUntil evaluates m's RequeueUntilAnnotationKey, if any, and
+// returns a resourcebuilder.RetryLaterError when the selector it names does
+// not yet match enough ready objects. shouldRequeueOnErr always requeues
+// that error regardless of m's other annotations, so the manifest is
+// retried through the normal backoff until the precondition is satisfied.
+func (b *resourceBuilder) checkRequeueUntil(m *lib.Manifest) error {
+	spec, err := parseRequeueUntilAnnotation(m.Object().GetAnnotations())
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		return nil
+	}
+
+	client, err := b.dynamicClientFor()
+	if err != nil {
+		return err
+	}
+
+	list, err := client.Resource(spec.gvr).Namespace(m.Object().GetNamespace()).List(context.TODO(), metav1.ListOptions{LabelSelector: spec.labelSelector})
+	if err != nil {
+		return err
+	}
+
+	var ready int
+	for i := range list.Items {
+		if isUnstructuredReady(&list.Items[i]) {
+			ready++
+		}
+	}
+	if ready < spec.minCount {
+		return &resourcebuilder.RetryLaterError{Reason: fmt.Sprintf("waiting for %d ready %s matching %q, have %d", spec.minCount, spec.gvr.Resource, spec.labelSelector, ready)}
+	}
+	return nil
+}
+
+// dynamicClientFor returns the dynamic client used to evaluate
+// RequeueUntilAnnotationKey, constructing it from b.config on first use.
+func (b *resourceBuilder) dynamicClientFor() (dynamic.Interface, error) {
+	if b.dynamicClient != nil {
+		return b.dynamicClient, nil
+	}
+	client, err := dynamic.NewForConfig(b.config)
+	if err != nil {
+		return nil, err
+	}
+	b.dynamicClient = client
+	return client, nil
+}
+
+// splitPreservedLabels partitions labels into the subset that should always
+// be written (destructive) and the subset named in preservedKeys, which
+// should only be written when the live object lacks them.
+func splitPreservedLabels(labels map[string]string, preservedKeys []string) (destructive, preserved map[string]string) {
+	preservedSet := make(map[string]bool, len(preservedKeys))
+	for _, k := range preservedKeys {
+		preservedSet[k] = true
+	}
+
+	destructive = make(map[string]string)
+	preserved = make(map[string]string)
+	for k, v := range labels {
+		if preservedSet[k] {
+			preserved[k] = v
+		} else {
+			destructive[k] = v
+		}
+	}
+	return destructive, preserved
+}
+
+// updatePayload is the decoded set of manifests for a single release image,
+// ready to be applied by the sync worker.
+type updatePayload struct {
+	ReleaseImage   string
+	ReleaseVersion string
+	Manifests      []lib.Manifest
+}
+
+// SyncWork describes the update the sync worker is currently converging
+// towards.
+type SyncWork struct {
+	Generation int64
+	Desired    configv1.Update
+	Overrides  []configv1.ComponentOverride
+
+	// Completed is the number of times this work has been applied
+	// successfully.
+	Completed int
+}
+
+// SyncWorkerStatus describes the detailed status of the current or last sync
+// attempt, used to drive the ClusterOperator/ClusterVersion status.
+type SyncWorkerStatus struct {
+	Generation int64
+
+	Step    string
+	Failure error
+
+	Done  int
+	Total int
+
+	Completed    int
+	Reconciling  bool
+	Initial      bool
+	VersionHash  string
+	LastProgress time.Time
+
+	Actual configv1.Release
+}
+
+// DeepCopy returns an independent copy of status.
+func (w *SyncWorkerStatus) DeepCopy() *SyncWorkerStatus {
+	if w == nil {
+		return nil
+	}
+	copied := *w
+	return &copied
+}
+
+// SyncWorker applies a sequence of manifests to the cluster, tracking status
+// as it goes and retrying manifests that opt into the requeue-on-error
+// behavior.
+type SyncWorker struct {
+	backoff wait.Backoff
+	builder ResourceBuilder
+
+	// MaxConcurrency bounds how many independent manifests (as determined
+	// by the dependency graph built from DependsOnAnnotationKey and the
+	// implicit Namespace/CRD ordering rules) apply is allowed to apply at
+	// once. Values less than or equal to 1 apply one manifest at a time, in
+	// dependency waves: every manifest whose dependencies are done, in
+	// manifest order, before any manifest of the next wave.
+	MaxConcurrency int
+
+	lock   sync.Mutex
+	status SyncWorkerStatus
+}
+
+// Status returns a copy of the worker's current status.
+func (w *SyncWorker) Status() *SyncWorkerStatus {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.status.DeepCopy()
+}
+
+// statusWrapper tracks the status of an in-flight apply so the worker can
+// report partial progress (and, on completion, compute the delta against the
+// last reported status).
+type statusWrapper struct {
+	w              *SyncWorker
+	previousStatus *SyncWorkerStatus
+}
+
+// Report updates the worker's status to reflect progress through the current
+// apply pass.
+func (r *statusWrapper) Report(done, total int, step string) {
+	r.w.lock.Lock()
+	defer r.w.lock.Unlock()
+	r.w.status.Done = done
+	r.w.status.Total = total
+	r.w.status.Step = step
+	r.w.status.LastProgress = time.Now()
+}
+
+// releaseLabelSetter is implemented by ResourceBuilder implementations that
+// stamp release-owned labels onto every manifest they apply; test doubles
+// that don't care about labeling can simply not implement it.
+type releaseLabelSetter interface {
+	setReleaseLabels(map[string]string)
+}
+
+// apply applies every manifest in payloadUpdate in dependency order, then
+// gives the manifests that failed with a requeue-eligible error (and the
+// dependents they blocked) one final pass. With the default serial
+// concurrency, a manifest whose error is not requeue-eligible fails the
+// apply immediately; with MaxConcurrency above 1 it only prunes its own
+// dependents, and its error is returned once the other manifests have run.
+func (w *SyncWorker) apply(ctx context.Context, payloadUpdate *updatePayload, work *SyncWork, status *statusWrapper) error {
+	if setter, ok := w.builder.(releaseLabelSetter); ok {
+		setter.setReleaseLabels(map[string]string{
+			"release.openshift.io/version": payloadUpdate.ReleaseVersion,
+		})
+	}
+
+	total := len(payloadUpdate.Manifests)
+	deps := buildDependencyGraph(payloadUpdate.Manifests)
+	all := make([]int, total)
+	for i := range all {
+		all[i] = i
+	}
+
+	first := w.applyIndices(ctx, payloadUpdate.Manifests, deps, all, status, total, false)
+	if first.err != nil && w.effectiveConcurrency() <= 1 {
+		return first.err
+	}
+	if len(first.retry) == 0 {
+		if first.err != nil {
+			return first.err
+		}
+		status.Report(total, total, "")
+		return nil
+	}
+
+	final := w.applyIndices(ctx, payloadUpdate.Manifests, deps, first.retry, status, total, true)
+	if final.err != nil {
+		return final.err
+	}
+	if first.err != nil {
+		return first.err
+	}
+
+	status.Report(total, total, "")
+	return nil
+}
+
+// applyManifestWithRetry attempts to apply m, retrying according to
+// w.backoff on any error, requeue-eligible or not. It returns the last error
+// seen once the backoff is exhausted.
+func (w *SyncWorker) applyManifestWithRetry(ctx context.Context, m *lib.Manifest) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(w.backoff, func() (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		applyErr := w.builder.Apply(m)
+		if applyErr == nil {
+			return true, nil
+		}
+		lastErr = &updateError{Name: m.String(), cause: applyErr}
+		// Keep retrying until the backoff is exhausted; whether this error
+		// is ultimately fatal or requeue-eligible is decided by the caller
+		// once the retry budget for this manifest runs out.
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	return err
+}
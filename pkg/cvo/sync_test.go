@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -23,6 +26,7 @@ import (
 	configv1 "github.com/openshift/api/config/v1"
 	"github.com/openshift/cluster-version-operator/lib"
 	"github.com/openshift/cluster-version-operator/lib/resourcebuilder"
+	cvocache "github.com/openshift/cluster-version-operator/pkg/cvo/cache"
 	"github.com/openshift/cluster-version-operator/pkg/cvo/internal"
 )
 
@@ -199,6 +203,133 @@ func TestShouldRequeueOnErr(t *testing.T) {
 	}
 }
 
+func TestParseRequeueUntilAnnotation(t *testing.T) {
+	tests := []struct {
+		annos map[string]string
+
+		exp     *requeueUntilSpec
+		wantErr bool
+	}{{
+		annos: nil,
+		exp:   nil,
+	}, {
+		annos: map[string]string{"dummy": "dummy"},
+		exp:   nil,
+	}, {
+		annos: map[string]string{RequeueUntilAnnotationKey: "apps/v1/deployments?labelSelector=app%3Dfoo&count>=3"},
+		exp: &requeueUntilSpec{
+			gvr:           schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			labelSelector: "app=foo",
+			minCount:      3,
+		},
+	}, {
+		annos: map[string]string{RequeueUntilAnnotationKey: "apps/v1/deployments"},
+		exp: &requeueUntilSpec{
+			gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		},
+	}, {
+		annos:   map[string]string{RequeueUntilAnnotationKey: "deployments"},
+		wantErr: true,
+	}, {
+		annos:   map[string]string{RequeueUntilAnnotationKey: "apps/v1/deployments?count>=notanumber"},
+		wantErr: true,
+	}}
+	for idx, test := range tests {
+		t.Run(fmt.Sprintf("test#%d", idx), func(t *testing.T) {
+			got, err := parseRequeueUntilAnnotation(test.annos)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.exp) {
+				t.Fatalf("expected %#v got %#v", test.exp, got)
+			}
+		})
+	}
+}
+
+func Test_resourceBuilder_checkRequeueUntil(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+
+	newDeployment := func(name string, ready bool) *unstructured.Unstructured {
+		status := "False"
+		if ready {
+			status = "True"
+		}
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+				"labels":    map[string]interface{}{"app": "foo"},
+			},
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Ready", "status": status},
+				},
+			},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		exp     bool // true if ready count satisfies the annotation
+	}{{
+		name:    "no matching objects",
+		objects: nil,
+		exp:     false,
+	}, {
+		name:    "not enough ready objects",
+		objects: []runtime.Object{newDeployment("a", true), newDeployment("b", false)},
+		exp:     false,
+	}, {
+		name:    "enough ready objects",
+		objects: []runtime.Object{newDeployment("a", true), newDeployment("b", true), newDeployment("c", true)},
+		exp:     true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+			scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DeploymentList"}, &unstructured.UnstructuredList{})
+			client := dynamicfake.NewSimpleDynamicClient(scheme, test.objects...)
+
+			m := lib.Manifest{}
+			if err := json.Unmarshal([]byte(`{
+				"apiVersion": "v1",
+				"kind": "ConfigMap",
+				"metadata": {
+					"namespace": "default",
+					"annotations": {
+						"v1.cluster-version-operator.operators.openshift.io/requeue-until": "apps/v1/deployments?labelSelector=app%3Dfoo&count>=3"
+					}
+				}
+			}`), &m); err != nil {
+				t.Fatal(err)
+			}
+
+			b := &resourceBuilder{dynamicClient: client}
+			err := b.checkRequeueUntil(&m)
+			if test.exp {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if _, ok := err.(*resourcebuilder.RetryLaterError); !ok {
+				t.Fatalf("expected a RetryLaterError, got %v", err)
+			}
+		})
+	}
+}
+
 func Test_SyncWorker_apply(t *testing.T) {
 	tests := []struct {
 		manifests []string
@@ -381,10 +512,10 @@ func Test_SyncWorker_apply(t *testing.T) {
 
 			worker := &SyncWorker{}
 			worker.backoff.Steps = 3
-			worker.builder = NewResourceBuilder(nil)
+			worker.builder = NewResourceBuilder(nil, nil)
 			ctx := context.Background()
 			worker.apply(ctx, up, &SyncWork{}, &statusWrapper{w: worker, previousStatus: worker.Status()})
-			test.check(t, r.actions)
+			test.check(t, r.Actions())
 		})
 	}
 }
@@ -569,6 +700,14 @@ func (t *testBuilder) WithModifier(m resourcebuilder.MetaV1ObjectModifierFunc) r
 	return t
 }
 
+func (t *testBuilder) WithNonDestructiveModifier(m resourcebuilder.NonDestructiveModifierFunc) resourcebuilder.Interface {
+	return t
+}
+
+func (t *testBuilder) WithReader(r resourcebuilder.Reader) resourcebuilder.Interface {
+	return t
+}
+
 func (t *testBuilder) Do() error {
 	a := t.recorder.Invoke(t.m.GVK, t.m.Object().GetNamespace(), t.m.Object().GetName())
 	return t.reactors[a]
@@ -580,16 +719,40 @@ func newTestBuilder(r *recorder, rts map[action]error) resourcebuilder.NewIntera
 	}
 }
 
+// recorder is shared across the goroutines SyncWorker.apply may use to apply
+// independent manifests concurrently, so every access is mutex-guarded.
 type recorder struct {
+	mu      sync.Mutex
 	actions []action
 }
 
 func (r *recorder) Invoke(gvk schema.GroupVersionKind, namespace, name string) action {
 	action := action{GVK: gvk, Namespace: namespace, Name: name}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.actions = append(r.actions, action)
 	return action
 }
 
+func (r *recorder) Actions() []action {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]action, len(r.actions))
+	copy(out, r.actions)
+	return out
+}
+
+// indexOf returns the position of the first action matching gvk/ns/name, or
+// -1 if it never ran.
+func indexOf(actions []action, gvk schema.GroupVersionKind, namespace, name string) int {
+	for i, a := range actions {
+		if a.GVK == gvk && a.Namespace == namespace && a.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
 type action struct {
 	GVK       schema.GroupVersionKind
 	Namespace string
@@ -650,6 +813,7 @@ func (r *fakePayloadRetriever) RetrievePayload(ctx context.Context, desired conf
 type testResourceBuilder struct {
 	client    *dynamicfake.FakeDynamicClient
 	modifiers []resourcebuilder.MetaV1ObjectModifierFunc
+	cache     *cvocache.InformersMap
 }
 
 func (b *testResourceBuilder) Apply(m *lib.Manifest) error {
@@ -663,5 +827,386 @@ func (b *testResourceBuilder) Apply(m *lib.Manifest) error {
 	for _, m := range b.modifiers {
 		builder = builder.WithModifier(m)
 	}
-	return builder.Do()
+	if b.cache != nil {
+		builder = builder.WithReader(b.cache)
+	}
+	if err := builder.Do(); err != nil {
+		return err
+	}
+	if b.cache != nil {
+		if wp, ok := builder.(resourcebuilder.WrittenObjectProvider); ok {
+			if written := wp.WrittenObject(); written != nil {
+				b.cache.Invalidate(m.GVK, written)
+			}
+		}
+	}
+	return nil
+}
+
+func Test_SyncWorker_apply_concurrent_respectsDependsOn(t *testing.T) {
+	manifests := []string{
+		`{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestB",
+			"metadata": {
+				"namespace": "default",
+				"name": "testb",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/depends-on": "test.cvo.io/v1/TestA/default/testa"
+				}
+			}
+		}`,
+		`{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestA",
+			"metadata": {
+				"namespace": "default",
+				"name": "testa"
+			}
+		}`,
+	}
+
+	var ms []lib.Manifest
+	for _, s := range manifests {
+		m := lib.Manifest{}
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		ms = append(ms, m)
+	}
+
+	up := &updatePayload{ReleaseImage: "test", ReleaseVersion: "v0.0.0", Manifests: ms}
+	r := &recorder{}
+	testMapper := resourcebuilder.NewResourceMapper()
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, newTestBuilder(r, nil))
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, newTestBuilder(r, nil))
+	testMapper.AddToMap(resourcebuilder.Mapper)
+
+	worker := &SyncWorker{MaxConcurrency: 4}
+	worker.backoff.Steps = 3
+	worker.builder = NewResourceBuilder(nil, nil)
+	ctx := context.Background()
+	if err := worker.apply(ctx, up, &SyncWork{}, &statusWrapper{w: worker, previousStatus: worker.Status()}); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := r.Actions()
+	aIdx := indexOf(actions, schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, "default", "testa")
+	bIdx := indexOf(actions, schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, "default", "testb")
+	if aIdx == -1 || bIdx == -1 {
+		t.Fatalf("expected both manifests to be applied, got %v", actions)
+	}
+	if aIdx > bIdx {
+		t.Fatalf("expected TestA (depended on) to be applied before TestB, got %v", actions)
+	}
+}
+
+// Test_SyncWorker_apply_serialRespectsDependsOn guards against a deadlock
+// in the default configuration: with MaxConcurrency unset, apply runs with
+// effectiveConcurrency()==1, and any dependency edge used to make a
+// finishing worker recurse into the scheduler while still holding the only
+// semaphore slot, hanging forever. All of the other concurrency tests in
+// this file set MaxConcurrency explicitly, so none of them would have
+// caught that.
+func Test_SyncWorker_apply_serialRespectsDependsOn(t *testing.T) {
+	manifests := []string{
+		`{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestB",
+			"metadata": {
+				"namespace": "default",
+				"name": "testb",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/depends-on": "test.cvo.io/v1/TestA/default/testa"
+				}
+			}
+		}`,
+		`{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestA",
+			"metadata": {
+				"namespace": "default",
+				"name": "testa"
+			}
+		}`,
+	}
+
+	var ms []lib.Manifest
+	for _, s := range manifests {
+		m := lib.Manifest{}
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		ms = append(ms, m)
+	}
+
+	up := &updatePayload{ReleaseImage: "test", ReleaseVersion: "v0.0.0", Manifests: ms}
+	r := &recorder{}
+	testMapper := resourcebuilder.NewResourceMapper()
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, newTestBuilder(r, nil))
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, newTestBuilder(r, nil))
+	testMapper.AddToMap(resourcebuilder.Mapper)
+
+	worker := &SyncWorker{}
+	worker.backoff.Steps = 3
+	worker.builder = NewResourceBuilder(nil, nil)
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.apply(ctx, up, &SyncWork{}, &statusWrapper{w: worker, previousStatus: worker.Status()})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("apply deadlocked with the default serial concurrency and a dependency edge")
+	}
+
+	actions := r.Actions()
+	aIdx := indexOf(actions, schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, "default", "testa")
+	bIdx := indexOf(actions, schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, "default", "testb")
+	if aIdx == -1 || bIdx == -1 {
+		t.Fatalf("expected both manifests to be applied, got %v", actions)
+	}
+	if aIdx > bIdx {
+		t.Fatalf("expected TestA (depended on) to be applied before TestB, got %v", actions)
+	}
+}
+
+func Test_SyncWorker_apply_concurrent_independentSiblingsNotBlocked(t *testing.T) {
+	manifest := `{
+		"apiVersion": "test.cvo.io/v1",
+		"kind": "%s",
+		"metadata": {
+			"namespace": "default",
+			"name": "%s",
+			"annotations": {
+				"v1.cluster-version-operator.operators.openshift.io/requeue-on-error": "NoMatch"
+			}
+		}
+	}`
+
+	var ms []lib.Manifest
+	for _, s := range []string{
+		fmt.Sprintf(manifest, "TestA", "testa"),
+		fmt.Sprintf(manifest, "TestB", "testb"),
+	} {
+		m := lib.Manifest{}
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		ms = append(ms, m)
+	}
+
+	up := &updatePayload{ReleaseImage: "test", ReleaseVersion: "v0.0.0", Manifests: ms}
+	r := &recorder{}
+	reactors := map[action]error{
+		newAction(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, "default", "testa"): &meta.NoResourceMatchError{},
+		newAction(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, "default", "testb"): &meta.NoResourceMatchError{},
+	}
+	testMapper := resourcebuilder.NewResourceMapper()
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, newTestBuilder(r, reactors))
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, newTestBuilder(r, reactors))
+	testMapper.AddToMap(resourcebuilder.Mapper)
+
+	worker := &SyncWorker{MaxConcurrency: 4}
+	worker.backoff.Steps = 3
+	worker.builder = NewResourceBuilder(nil, nil)
+	ctx := context.Background()
+	err := worker.apply(ctx, up, &SyncWork{}, &statusWrapper{w: worker, previousStatus: worker.Status()})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Both manifests are independent (no depends-on edge between them), so
+	// TestB's final retry pass must not be skipped just because TestA is
+	// still failing: each gets its own full 3-attempt backoff in both the
+	// initial pass and the final retry pass, for 12 actions total, rather
+	// than the 9 a strictly serial, abort-on-first-failure final pass would
+	// produce.
+	if got, exp := len(r.Actions()), 12; got != exp {
+		spew.Dump(r.Actions())
+		t.Fatalf("expected %d actions, got %d", exp, got)
+	}
+}
+
+// Test_SyncWorker_apply_cachedReads asserts that once an InformersMap has
+// warmed its cache for a GVK, repeated apply passes over the same manifests
+// are serviced entirely from the cache: no further "get" actions reach the
+// live client.
+func Test_SyncWorker_apply_cachedReads(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestAList"}, &unstructured.UnstructuredList{})
+	mapper := testrestmapper.NewTestRESTMapper(scheme, schema.GroupVersion{Group: "test.cvo.io", Version: "v1"})
+
+	client := dynamicfake.NewSimpleDynamicClient(scheme)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informers := cvocache.NewInformersMap(client, mapper, stopCh)
+
+	m := lib.Manifest{}
+	if err := json.Unmarshal([]byte(`{
+		"apiVersion": "test.cvo.io/v1",
+		"kind": "TestA",
+		"metadata": {
+			"namespace": "default",
+			"name": "testa"
+		}
+	}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	up := &updatePayload{ReleaseImage: "test", ReleaseVersion: "v0.0.0", Manifests: []lib.Manifest{m}}
+
+	worker := &SyncWorker{}
+	worker.backoff.Steps = 1
+	worker.builder = &testResourceBuilder{client: client, cache: informers}
+	ctx := context.Background()
+
+	// The first pass creates the object and warms the cache with it.
+	if err := worker.apply(ctx, up, &SyncWork{}, &statusWrapper{w: worker, previousStatus: worker.Status()}); err != nil {
+		t.Fatal(err)
+	}
+	// The first pass starts with a cold cache, so its live Get is expected;
+	// only actions from the second pass onwards are checked below.
+	warm := len(client.Actions())
+	// A second pass over the same manifest finds nothing to change, but
+	// still issues an Update: it must resolve the existing object from the
+	// cache rather than a live Get.
+	if err := worker.apply(ctx, up, &SyncWork{}, &statusWrapper{w: worker, previousStatus: worker.Status()}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range client.Actions()[warm:] {
+		if a.GetVerb() == "get" {
+			spew.Dump(client.Actions())
+			t.Fatalf("expected no live Get actions once the cache is warm, got %v", a)
+		}
+	}
+	if rate := informers.Metrics.HitRate(); rate == 0 {
+		t.Fatalf("expected a non-zero cache hit rate, got %v", rate)
+	}
+}
+
+// flakyBuilder fails every Apply with a requeue-eligible error until it has
+// been invoked more than failures times, then succeeds. It lets a test pin
+// down a dependency that exhausts its retry budget in the first pass but
+// converges once the final, dependency-aware pass gives it one more try.
+type flakyBuilder struct {
+	recorder *recorder
+	m        *lib.Manifest
+	state    *flakyState
+}
+
+type flakyState struct {
+	mu       sync.Mutex
+	calls    int
+	failures int
+}
+
+func (b *flakyBuilder) WithModifier(resourcebuilder.MetaV1ObjectModifierFunc) resourcebuilder.Interface {
+	return b
+}
+
+func (b *flakyBuilder) WithNonDestructiveModifier(resourcebuilder.NonDestructiveModifierFunc) resourcebuilder.Interface {
+	return b
+}
+
+func (b *flakyBuilder) WithReader(resourcebuilder.Reader) resourcebuilder.Interface {
+	return b
+}
+
+func (b *flakyBuilder) Do() error {
+	b.recorder.Invoke(b.m.GVK, b.m.Object().GetNamespace(), b.m.Object().GetName())
+
+	b.state.mu.Lock()
+	b.state.calls++
+	fail := b.state.calls <= b.state.failures
+	b.state.mu.Unlock()
+
+	if fail {
+		return &meta.NoResourceMatchError{}
+	}
+	return nil
+}
+
+func newFlakyBuilder(r *recorder, failures int) resourcebuilder.NewInteraceFunc {
+	state := &flakyState{failures: failures}
+	return func(_ *rest.Config, m lib.Manifest) resourcebuilder.Interface {
+		return &flakyBuilder{recorder: r, m: &m, state: state}
+	}
+}
+
+// Test_SyncWorker_apply_concurrent_retriedDependencyUnblocksDependents
+// guards against a manifest being silently dropped for an entire apply()
+// call when the dependency it waits on exhausts its retry budget in the
+// first pass but goes on to succeed in the final pass: the dependent must
+// be carried into that final pass too, not pruned and forgotten.
+func Test_SyncWorker_apply_concurrent_retriedDependencyUnblocksDependents(t *testing.T) {
+	manifests := []string{
+		`{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestA",
+			"metadata": {
+				"namespace": "default",
+				"name": "testa",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/requeue-on-error": "NoMatch"
+				}
+			}
+		}`,
+		`{
+			"apiVersion": "test.cvo.io/v1",
+			"kind": "TestB",
+			"metadata": {
+				"namespace": "default",
+				"name": "testb",
+				"annotations": {
+					"v1.cluster-version-operator.operators.openshift.io/depends-on": "test.cvo.io/v1/TestA/default/testa"
+				}
+			}
+		}`,
+	}
+
+	var ms []lib.Manifest
+	for _, s := range manifests {
+		m := lib.Manifest{}
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		ms = append(ms, m)
+	}
+
+	up := &updatePayload{ReleaseImage: "test", ReleaseVersion: "v0.0.0", Manifests: ms}
+	r := &recorder{}
+	testMapper := resourcebuilder.NewResourceMapper()
+	// TestA fails its entire first-pass retry budget (backoff.Steps == 3),
+	// then succeeds on its first attempt in the final pass.
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, newFlakyBuilder(r, 3))
+	testMapper.RegisterGVK(schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, newTestBuilder(r, nil))
+	testMapper.AddToMap(resourcebuilder.Mapper)
+
+	worker := &SyncWorker{MaxConcurrency: 4}
+	worker.backoff.Steps = 3
+	worker.builder = NewResourceBuilder(nil, nil)
+	ctx := context.Background()
+	if err := worker.apply(ctx, up, &SyncWork{}, &statusWrapper{w: worker, previousStatus: worker.Status()}); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := r.Actions()
+	aIdx := indexOf(actions, schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestA"}, "default", "testa")
+	bIdx := indexOf(actions, schema.GroupVersionKind{Group: "test.cvo.io", Version: "v1", Kind: "TestB"}, "default", "testb")
+	if bIdx == -1 {
+		t.Fatalf("expected TestB to be applied once its dependency's retry succeeded, got %v", actions)
+	}
+	if aIdx > bIdx {
+		t.Fatalf("expected TestA (depended on) to be applied before TestB, got %v", actions)
+	}
 }